@@ -0,0 +1,228 @@
+package main
+
+import (
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+)
+
+// PullRequestData bundles the pull request data fetched for an issue that
+// is actually a pull request, so that a backup can round-trip enough data
+// to reconstruct a discussion (e.g. on a bridge like the git-bug GitHub
+// importer).
+type PullRequestData struct {
+	PR             *github.PullRequest          `json:"pr"`
+	Reviews        []*github.PullRequestReview  `json:"reviews"`
+	ReviewComments []*github.PullRequestComment `json:"review_comments"`
+	Commits        []*github.RepositoryCommit   `json:"commits"`
+}
+
+// getIssueReactions returns the reactions of the given issue number.
+func (c *Client) getIssueReactions(ownerName, repoName string, issueNumber int) ([]*github.Reaction, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	var all []*github.Reaction
+	for {
+		reactions, resp, err := c.client.Reactions.ListIssueReactions(c.ctx, ownerName, repoName, issueNumber, opt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				reactions, resp, err = c.client.Reactions.ListIssueReactions(c.ctx, ownerName, repoName, issueNumber, opt)
+			}
+			if err != nil {
+				warn.Printf("unable to get reactions of %s:%s issue #%d; %v", ownerName, repoName, issueNumber, err)
+				break
+			}
+		}
+		all = append(all, reactions...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// getIssueTimeline returns the timeline events of the given issue number,
+// e.g. labeled/unlabeled, assigned/unassigned, closed/reopened, and
+// cross-referenced events.
+func (c *Client) getIssueTimeline(ownerName, repoName string, issueNumber int) ([]*github.Timeline, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	var all []*github.Timeline
+	for {
+		events, resp, err := c.client.Issues.ListIssueTimeline(c.ctx, ownerName, repoName, issueNumber, opt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				events, resp, err = c.client.Issues.ListIssueTimeline(c.ctx, ownerName, repoName, issueNumber, opt)
+			}
+			if err != nil {
+				warn.Printf("unable to get timeline of %s:%s issue #%d; %v", ownerName, repoName, issueNumber, err)
+				break
+			}
+		}
+		all = append(all, events...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// getLabels returns the labels defined in the given owner/repo.
+func (c *Client) getLabels(ownerName, repoName string) ([]*github.Label, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	var all []*github.Label
+	for {
+		labels, resp, err := c.client.Issues.ListLabels(c.ctx, ownerName, repoName, opt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				labels, resp, err = c.client.Issues.ListLabels(c.ctx, ownerName, repoName, opt)
+			}
+			if err != nil {
+				warn.Printf("unable to get labels of %s:%s; %v", ownerName, repoName, err)
+				break
+			}
+		}
+		all = append(all, labels...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// getMilestones returns the milestones defined in the given owner/repo.
+func (c *Client) getMilestones(ownerName, repoName string) ([]*github.Milestone, error) {
+	opt := &github.MilestoneListOptions{
+		State:       "all",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var all []*github.Milestone
+	for {
+		milestones, resp, err := c.client.Issues.ListMilestones(c.ctx, ownerName, repoName, opt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				milestones, resp, err = c.client.Issues.ListMilestones(c.ctx, ownerName, repoName, opt)
+			}
+			if err != nil {
+				warn.Printf("unable to get milestones of %s:%s; %v", ownerName, repoName, err)
+				break
+			}
+		}
+		all = append(all, milestones...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// getPullRequest returns the pull request data of the given issue number,
+// which must actually be a pull request (see github.Issue.IsPullRequest).
+// Like the other fetchers in this file, a page that still fails after the
+// rate limit resets is logged and skipped rather than aborting the whole
+// backup; nil, nil is returned if the pull request itself can't be fetched.
+func (c *Client) getPullRequest(ownerName, repoName string, number int) (*PullRequestData, error) {
+	pr, _, err := c.client.PullRequests.Get(c.ctx, ownerName, repoName, number)
+	if err != nil {
+		for waitForRateLimitReset(err) {
+			pr, _, err = c.client.PullRequests.Get(c.ctx, ownerName, repoName, number)
+		}
+		if err != nil {
+			warn.Printf("unable to get pull request %s:%s#%d; %v", ownerName, repoName, number, err)
+			return nil, nil
+		}
+	}
+	data := &PullRequestData{PR: pr}
+	reviewOpt := &github.ListOptions{PerPage: 100}
+	for {
+		reviews, resp, err := c.client.PullRequests.ListReviews(c.ctx, ownerName, repoName, number, reviewOpt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				reviews, resp, err = c.client.PullRequests.ListReviews(c.ctx, ownerName, repoName, number, reviewOpt)
+			}
+			if err != nil {
+				warn.Printf("unable to get reviews of %s:%s#%d; %v", ownerName, repoName, number, err)
+				break
+			}
+		}
+		data.Reviews = append(data.Reviews, reviews...)
+		if resp.NextPage == 0 {
+			break
+		}
+		reviewOpt.Page = resp.NextPage
+	}
+	commentOpt := &github.PullRequestListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := c.client.PullRequests.ListComments(c.ctx, ownerName, repoName, number, commentOpt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				comments, resp, err = c.client.PullRequests.ListComments(c.ctx, ownerName, repoName, number, commentOpt)
+			}
+			if err != nil {
+				warn.Printf("unable to get review comments of %s:%s#%d; %v", ownerName, repoName, number, err)
+				break
+			}
+		}
+		data.ReviewComments = append(data.ReviewComments, comments...)
+		if resp.NextPage == 0 {
+			break
+		}
+		commentOpt.Page = resp.NextPage
+	}
+	commitOpt := &github.ListOptions{PerPage: 100}
+	for {
+		commits, resp, err := c.client.PullRequests.ListCommits(c.ctx, ownerName, repoName, number, commitOpt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				commits, resp, err = c.client.PullRequests.ListCommits(c.ctx, ownerName, repoName, number, commitOpt)
+			}
+			if err != nil {
+				warn.Printf("unable to get commits of %s:%s#%d; %v", ownerName, repoName, number, err)
+				break
+			}
+		}
+		data.Commits = append(data.Commits, commits...)
+		if resp.NextPage == 0 {
+			break
+		}
+		commitOpt.Page = resp.NextPage
+	}
+	return data, nil
+}
+
+// enrichIssue fetches and persists the reactions, timeline, and (if the
+// issue is a pull request) pull request data of the given issue, as
+// selected by opts.
+func (c *Client) enrichIssue(opts backupOptions, store Store, issue *github.Issue) error {
+	if opts.withReactions {
+		reactions, err := c.getIssueReactions(opts.ownerName, opts.repoName, issue.GetNumber())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := store.PutReactions(issue.GetNumber(), reactions); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if opts.withTimeline {
+		events, err := c.getIssueTimeline(opts.ownerName, opts.repoName, issue.GetNumber())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := store.PutTimeline(issue.GetNumber(), events); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if opts.withPRs && issue.IsPullRequest() {
+		data, err := c.getPullRequest(opts.ownerName, opts.repoName, issue.GetNumber())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if data != nil {
+			if err := store.PutPullRequest(issue.GetNumber(), data); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"github.com/shurcooL/githubv4"
+)
+
+// issuesPerQuery is the number of issues fetched per GraphQL query. Issues
+// and their first page of comments are fetched together, so this also
+// bounds the number of nested comment connections per query.
+const issuesPerQuery = 50
+
+// commentsPerIssue is the number of comments fetched per issue in the
+// initial GraphQL query. Issues with more comments than this are paged
+// separately via commentsFromNode.
+const commentsPerIssue = 100
+
+// graphqlClient is an OAuth authenticated GitHub client that fetches issues
+// and comments via the GitHub GraphQL v4 API, using connection-based
+// pagination to batch many issues and their comments into a single query
+// per page. This uses far fewer requests than the REST backend (Client),
+// which issues one request per issue to fetch comments.
+type graphqlClient struct {
+	ctx    context.Context
+	client *githubv4.Client
+}
+
+// newGraphQLClient returns a GraphQL GitHub client authenticated with the
+// given OAuth token, using the same rate-limit-aware, caching transport as
+// the REST client (see newHTTPClient).
+func newGraphQLClient(token string, rateFraction float64, cacheDir string) *graphqlClient {
+	ctx := context.Background()
+	tc := newHTTPClient(ctx, token, rateFraction, cacheDir)
+	client := githubv4.NewClient(tc)
+	return &graphqlClient{
+		ctx:    ctx,
+		client: client,
+	}
+}
+
+// issueNode mirrors the fields selected from the `issues` connection of a
+// repository query.
+type issueNode struct {
+	Number    int
+	Title     githubv4.String
+	Body      githubv4.String
+	State     githubv4.String
+	Author    actorNode
+	CreatedAt githubv4.DateTime
+	UpdatedAt githubv4.DateTime
+	ClosedAt  githubv4.DateTime
+	Labels    labelConnection    `graphql:"labels(first: 100)"`
+	Assignees assigneeConnection `graphql:"assignees(first: 100)"`
+	Comments  commentConnection  `graphql:"comments(first: $commentsPerIssue)"`
+}
+
+// actorNode mirrors the fields selected from a GraphQL `Actor` (the author
+// of an issue or comment).
+type actorNode struct {
+	Login githubv4.String
+}
+
+// labelConnection mirrors the `labels` connection of an issue.
+type labelConnection struct {
+	Nodes []struct {
+		Name githubv4.String
+	}
+}
+
+// assigneeConnection mirrors the `assignees` connection of an issue.
+type assigneeConnection struct {
+	Nodes []actorNode
+}
+
+// commentConnection mirrors the `comments` connection of an issue.
+type commentConnection struct {
+	Nodes      []commentNode
+	PageInfo   pageInfo
+	TotalCount int
+}
+
+// commentNode mirrors the fields selected from the `comments` connection of
+// an issue.
+type commentNode struct {
+	DatabaseID int64
+	Body       githubv4.String
+	Author     actorNode
+	CreatedAt  githubv4.DateTime
+	UpdatedAt  githubv4.DateTime
+}
+
+// pageInfo mirrors the standard GraphQL Relay `pageInfo` connection field.
+type pageInfo struct {
+	HasNextPage bool
+	EndCursor   githubv4.String
+}
+
+// issuesQuery is the GraphQL query used to fetch a page of issues (and
+// their first page of comments) of a repository.
+type issuesQuery struct {
+	Repository struct {
+		Issues struct {
+			Nodes    []issueNode
+			PageInfo pageInfo
+		} `graphql:"issues(first: $issuesPerQuery, after: $issuesCursor, orderBy: {field: UPDATED_AT, direction: ASC})"`
+	} `graphql:"repository(owner: $owner, name: $repo)"`
+}
+
+// commentsQuery is the GraphQL query used to fetch subsequent pages of
+// comments of an issue that has more than commentsPerIssue comments.
+type commentsQuery struct {
+	Repository struct {
+		Issue struct {
+			Comments commentConnection `graphql:"comments(first: $commentsPerIssue, after: $commentsCursor)"`
+		} `graphql:"issue(number: $issueNumber)"`
+	} `graphql:"repository(owner: $owner, name: $repo)"`
+}
+
+// getIssues returns the issues of the given owner/repo, along with their
+// comments, fetched via the GraphQL API.
+func (c *graphqlClient) getIssues(ownerName, repoName string) ([]*github.Issue, map[int][]*github.IssueComment, error) {
+	var allIssues []*github.Issue
+	allComments := make(map[int][]*github.IssueComment)
+	vars := map[string]interface{}{
+		"owner":            githubv4.String(ownerName),
+		"repo":             githubv4.String(repoName),
+		"issuesPerQuery":   githubv4.Int(issuesPerQuery),
+		"commentsPerIssue": githubv4.Int(commentsPerIssue),
+		"issuesCursor":     (*githubv4.String)(nil),
+	}
+	for {
+		var q issuesQuery
+		if err := c.client.Query(c.ctx, &q, vars); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		for _, node := range q.Repository.Issues.Nodes {
+			issue := issueFromNode(node)
+			allIssues = append(allIssues, issue)
+			comments, err := c.commentsFromNode(ownerName, repoName, node)
+			if err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			allComments[node.Number] = comments
+		}
+		if !q.Repository.Issues.PageInfo.HasNextPage {
+			break
+		}
+		vars["issuesCursor"] = githubv4.NewString(q.Repository.Issues.PageInfo.EndCursor)
+	}
+	return allIssues, allComments, nil
+}
+
+// commentsFromNode returns the comments of the given issue node, following
+// the `comments` connection's own pageInfo cursor for issues with more than
+// commentsPerIssue comments.
+func (c *graphqlClient) commentsFromNode(ownerName, repoName string, node issueNode) ([]*github.IssueComment, error) {
+	comments := commentsFromNodes(node.Comments.Nodes)
+	pi := node.Comments.PageInfo
+	for pi.HasNextPage {
+		var q commentsQuery
+		vars := map[string]interface{}{
+			"owner":            githubv4.String(ownerName),
+			"repo":             githubv4.String(repoName),
+			"issueNumber":      githubv4.Int(node.Number),
+			"commentsPerIssue": githubv4.Int(commentsPerIssue),
+			"commentsCursor":   githubv4.NewString(pi.EndCursor),
+		}
+		if err := c.client.Query(c.ctx, &q, vars); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		comments = append(comments, commentsFromNodes(q.Repository.Issue.Comments.Nodes)...)
+		pi = q.Repository.Issue.Comments.PageInfo
+	}
+	return comments, nil
+}
+
+// issueFromNode maps a GraphQL issue node to the github.Issue shape used by
+// the REST backend, so that both backends produce JSON of the same shape
+// and existing backups remain readable regardless of which API fetched
+// them. Only the fields selected by issueNode are set; REST-only fields
+// such as the issue ID, URLs and milestone are left empty.
+func issueFromNode(node issueNode) *github.Issue {
+	issue := &github.Issue{
+		Number: github.Int(node.Number),
+		Title:  github.String(string(node.Title)),
+		Body:   github.String(string(node.Body)),
+		// GraphQL reports OPEN/CLOSED; REST uses open/closed.
+		State:     github.String(strings.ToLower(string(node.State))),
+		CreatedAt: &node.CreatedAt.Time,
+		UpdatedAt: &node.UpdatedAt.Time,
+		Comments:  github.Int(node.Comments.TotalCount),
+	}
+	if login := string(node.Author.Login); len(login) > 0 {
+		issue.User = &github.User{Login: github.String(login)}
+	}
+	if !node.ClosedAt.Time.IsZero() {
+		issue.ClosedAt = &node.ClosedAt.Time
+	}
+	for _, label := range node.Labels.Nodes {
+		issue.Labels = append(issue.Labels, &github.Label{Name: github.String(string(label.Name))})
+	}
+	for _, assignee := range node.Assignees.Nodes {
+		login := string(assignee.Login)
+		issue.Assignees = append(issue.Assignees, &github.User{Login: github.String(login)})
+	}
+	return issue
+}
+
+// commentsFromNodes maps GraphQL comment nodes to the github.IssueComment
+// shape used by the REST backend.
+func commentsFromNodes(nodes []commentNode) []*github.IssueComment {
+	var comments []*github.IssueComment
+	for _, node := range nodes {
+		createdAt, updatedAt := node.CreatedAt.Time, node.UpdatedAt.Time
+		comment := &github.IssueComment{
+			ID:        github.Int64(node.DatabaseID),
+			Body:      github.String(string(node.Body)),
+			CreatedAt: &createdAt,
+			UpdatedAt: &updatedAt,
+		}
+		if login := string(node.Author.Login); len(login) > 0 {
+			comment.User = &github.User{Login: github.String(login)}
+		}
+		comments = append(comments, comment)
+	}
+	return comments
+}
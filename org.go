@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+)
+
+// defaultConcurrency is the default -concurrency flag value.
+const defaultConcurrency = 4
+
+// repoResult is the outcome of backing up a single repository in a
+// multi-repo run.
+type repoResult struct {
+	repoName string
+	err      error
+}
+
+// backupOrg backs up every repository of opts.ownerName that matches the
+// -repos/-include/-exclude filters, running up to opts.concurrency backups
+// in parallel over a shared, rate-limited client. Per-repo errors are
+// collected and reported together rather than aborting the whole run.
+func backupOrg(opts backupOptions) error {
+	c := newClient(opts.token, opts.rateFraction, opts.cacheDir)
+	repoNames, err := resolveRepoNames(c, opts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(repoNames) == 0 {
+		return errors.Errorf("no repositories matched for owner %q", opts.ownerName)
+	}
+	dbg.Printf("backing up %d repositories of %s", len(repoNames), opts.ownerName)
+
+	// Share one rate-limited client (and, for the v4 backend, one GraphQL
+	// client) across every worker, so that concurrency workers pace
+	// themselves against a single token bucket instead of each pacing to
+	// -rate-fraction independently.
+	var gc *graphqlClient
+	if opts.api == "v4" {
+		gc = newGraphQLClient(opts.token, opts.rateFraction, opts.cacheDir)
+	}
+
+	concurrency := opts.concurrency
+	if concurrency < 1 {
+		concurrency = defaultConcurrency
+	}
+	jobs := make(chan string)
+	results := make(chan repoResult, len(repoNames))
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for repoName := range jobs {
+				results <- repoResult{repoName: repoName, err: backupRepo(opts, repoName, c, gc)}
+			}
+		}()
+	}
+	go func() {
+		for _, repoName := range repoNames {
+			jobs <- repoName
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed []repoResult
+	for result := range results {
+		if result.err != nil {
+			warn.Printf("backup of %s:%s failed; %+v", opts.ownerName, result.repoName, result.err)
+			failed = append(failed, result)
+			continue
+		}
+		dbg.Printf("backup of %s:%s complete", opts.ownerName, result.repoName)
+	}
+	if len(failed) > 0 {
+		names := make([]string, len(failed))
+		for i, result := range failed {
+			names[i] = result.repoName
+		}
+		return errors.Errorf("%d of %d repositories failed to back up: %s", len(failed), len(repoNames), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// backupRepo backs up a single repository of a multi-repo run, using a
+// store and state file scoped to that repository so that concurrent
+// workers never write to the same path, and c/gc, the REST/GraphQL clients
+// shared across the whole run (see backupOrg).
+func backupRepo(opts backupOptions, repoName string, c *Client, gc *graphqlClient) error {
+	repoOpts := opts
+	repoOpts.repoName = repoName
+	repoOpts.storeSpec = repoStoreSpec(opts.storeSpec, repoName)
+	repoOpts.statePath = repoStatePath(opts.statePath, repoName)
+	return backupIssues(repoOpts, c, gc)
+}
+
+// resolveRepoNames returns the repository names to back up for opts: the
+// -repos list if given, otherwise every repository of opts.ownerName (via
+// listOrgRepos), filtered by -include/-exclude globs.
+func resolveRepoNames(c *Client, opts backupOptions) ([]string, error) {
+	var names []string
+	if len(opts.repoNames) > 0 {
+		names = opts.repoNames
+	} else {
+		repos, err := listOrgRepos(c, opts.ownerName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		names = repos
+	}
+	var filtered []string
+	for _, name := range names {
+		if len(opts.include) > 0 {
+			if ok, err := path.Match(opts.include, name); err != nil {
+				return nil, errors.WithStack(err)
+			} else if !ok {
+				continue
+			}
+		}
+		if len(opts.exclude) > 0 {
+			if ok, err := path.Match(opts.exclude, name); err != nil {
+				return nil, errors.WithStack(err)
+			} else if ok {
+				continue
+			}
+		}
+		filtered = append(filtered, name)
+	}
+	return filtered, nil
+}
+
+// listOrgRepos returns the names of every repository owned by ownerName,
+// trying the organization listing endpoint first and falling back to the
+// user listing endpoint (Repositories.ListByOrg 404s for plain users).
+func listOrgRepos(c *Client, ownerName string) ([]string, error) {
+	opt := &github.RepositoryListByOrgOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var names []string
+	for {
+		repos, resp, err := c.client.Repositories.ListByOrg(c.ctx, ownerName, opt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				repos, resp, err = c.client.Repositories.ListByOrg(c.ctx, ownerName, opt)
+			}
+			if err != nil {
+				if resp != nil && resp.StatusCode == http.StatusNotFound {
+					return listUserRepos(c, ownerName)
+				}
+				return nil, errors.WithStack(err)
+			}
+		}
+		for _, repo := range repos {
+			names = append(names, repo.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return names, nil
+}
+
+// listUserRepos returns the names of every repository owned by ownerName,
+// via the user (rather than organization) repository listing endpoint.
+func listUserRepos(c *Client, ownerName string) ([]string, error) {
+	opt := &github.RepositoryListOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var names []string
+	for {
+		repos, resp, err := c.client.Repositories.List(c.ctx, ownerName, opt)
+		if err != nil {
+			for waitForRateLimitReset(err) {
+				repos, resp, err = c.client.Repositories.List(c.ctx, ownerName, opt)
+			}
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		for _, repo := range repos {
+			names = append(names, repo.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return names, nil
+}
+
+// repoStoreSpec scopes a -store spec to a single repository of a multi-repo
+// run, so that each repository's backup lands in its own directory, bucket
+// prefix, or database file.
+func repoStoreSpec(spec, repoName string) string {
+	switch {
+	case strings.HasPrefix(spec, "s3://"), strings.HasPrefix(spec, "gs://"):
+		return strings.TrimSuffix(spec, "/") + "/" + repoName
+	case strings.HasPrefix(spec, "sqlite:"), spec == "sqlite":
+		file := strings.TrimPrefix(spec, "sqlite:")
+		if len(file) == 0 {
+			file = defaultSQLiteFile
+		}
+		ext := filepath.Ext(file)
+		return "sqlite:" + strings.TrimSuffix(file, ext) + "-" + repoName + ext
+	default: // "fs:DIR" or "fs" or ""
+		dir := strings.TrimPrefix(spec, "fs:")
+		if len(dir) == 0 {
+			dir = defaultFSDir
+		}
+		return "fs:" + filepath.Join(dir, repoName)
+	}
+}
+
+// repoStatePath scopes a -state path to a single repository of a multi-repo
+// run, so that concurrent workers track incremental progress independently.
+func repoStatePath(path, repoName string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + repoName + ext
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/mewkiz/pkg/jsonutil"
+	"github.com/pkg/errors"
+)
+
+// defaultStateFile is the default path of the file used to persist
+// incremental backup progress between runs.
+const defaultStateFile = ".issue-backup-state.json"
+
+// State tracks the progress of previous backup runs so that subsequent runs
+// only need to fetch issues and comments that have changed since.
+type State struct {
+	// LastUpdated is the `updated_at` timestamp of the most recently seen
+	// issue, used to seed the `since` filter of the next run.
+	LastUpdated time.Time `json:"last_updated"`
+	// CommentCursor maps issue number to the `created_at` timestamp of the
+	// most recently seen comment of that issue.
+	CommentCursor map[int]time.Time `json:"comment_cursor"`
+}
+
+// loadState reads backup state from the given path. A zero-value State is
+// returned if the file does not exist, so the first run of a backup fetches
+// the full history.
+func loadState(path string) (*State, error) {
+	state := &State{
+		CommentCursor: make(map[int]time.Time),
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	if err := json.Unmarshal(buf, state); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if state.CommentCursor == nil {
+		state.CommentCursor = make(map[int]time.Time)
+	}
+	return state, nil
+}
+
+// storeState writes backup state to the given path.
+func storeState(path string, state *State) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	if err := jsonutil.Write(f, state); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/mewkiz/pkg/jsonutil"
+	"github.com/pkg/errors"
+)
+
+// defaultFSDir is the default directory of the fs store.
+const defaultFSDir = "backup"
+
+// fsStore is a Store backed by a directory tree with one JSON file per
+// issue (e.g. "0001.json") and, alongside it, one JSON file per issue's
+// comments (e.g. "0001.comments.json"), so that backups are reviewable with
+// a regular diff tool.
+type fsStore struct {
+	// dir is the root directory of the backup.
+	dir string
+}
+
+// newFSStore returns a Store rooted at the given directory, creating it if
+// it does not already exist.
+func newFSStore(dir string) (*fsStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &fsStore{dir: dir}, nil
+}
+
+// issuePath returns the path of the JSON file of the given issue number.
+func (s *fsStore) issuePath(issueNumber int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%04d.json", issueNumber))
+}
+
+// commentsPath returns the path of the JSON file of the comments of the
+// given issue number.
+func (s *fsStore) commentsPath(issueNumber int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%04d.comments.json", issueNumber))
+}
+
+// PutIssue persists the given issue, overwriting any previously stored
+// version of the same issue.
+func (s *fsStore) PutIssue(issue *github.Issue) error {
+	f, err := os.Create(s.issuePath(issue.GetNumber()))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	return errors.WithStack(jsonutil.Write(f, issue))
+}
+
+// PutComments persists the comments of the given issue number, merging into
+// any comments already stored for the issue (see mergeComments).
+func (s *fsStore) PutComments(issueNumber int, comments []*github.IssueComment) error {
+	path := s.commentsPath(issueNumber)
+	var existing []*github.IssueComment
+	if buf, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(buf, &existing); err != nil {
+			return errors.WithStack(err)
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return s.writeJSON(path, mergeComments(existing, comments))
+}
+
+// PutReactions persists the reactions of the given issue number.
+func (s *fsStore) PutReactions(issueNumber int, reactions []*github.Reaction) error {
+	path := filepath.Join(s.dir, fmt.Sprintf("%04d.reactions.json", issueNumber))
+	return s.writeJSON(path, reactions)
+}
+
+// PutTimeline persists the timeline events of the given issue number.
+func (s *fsStore) PutTimeline(issueNumber int, events []*github.Timeline) error {
+	path := filepath.Join(s.dir, fmt.Sprintf("%04d.timeline.json", issueNumber))
+	return s.writeJSON(path, events)
+}
+
+// PutLabels persists the repository's labels.
+func (s *fsStore) PutLabels(labels []*github.Label) error {
+	return s.writeJSON(filepath.Join(s.dir, "labels.json"), labels)
+}
+
+// PutMilestones persists the repository's milestones.
+func (s *fsStore) PutMilestones(milestones []*github.Milestone) error {
+	return s.writeJSON(filepath.Join(s.dir, "milestones.json"), milestones)
+}
+
+// PutPullRequest persists the pull request data of the given issue number.
+func (s *fsStore) PutPullRequest(issueNumber int, pr *PullRequestData) error {
+	path := filepath.Join(s.dir, fmt.Sprintf("%04d.pr.json", issueNumber))
+	return s.writeJSON(path, pr)
+}
+
+// writeJSON writes v to path as JSON, overwriting any existing file.
+func (s *fsStore) writeJSON(path string, v interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	return errors.WithStack(jsonutil.Write(f, v))
+}
+
+// LastUpdated returns the `updated_at` timestamp of the most recently
+// stored issue, scanning every issue file in the backup directory.
+func (s *fsStore) LastUpdated() (time.Time, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+	var lastUpdated time.Time
+	for _, match := range matches {
+		buf, err := ioutil.ReadFile(match)
+		if err != nil {
+			return time.Time{}, errors.WithStack(err)
+		}
+		var issue github.Issue
+		if err := json.Unmarshal(buf, &issue); err != nil {
+			continue // not an issue file, e.g. a *.comments.json file
+		}
+		if updated := issue.GetUpdatedAt(); updated.After(lastUpdated) {
+			lastUpdated = updated
+		}
+	}
+	return lastUpdated, nil
+}
+
+// Close is a no-op for the fs store, which performs no buffering between
+// writes.
+func (s *fsStore) Close() error {
+	return nil
+}
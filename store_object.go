@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+)
+
+// objectMeta is the metadata object maintained alongside the issue and
+// comment objects of an objectStore backup, so that LastUpdated does not
+// need to list and read every object in the bucket on each run.
+type objectMeta struct {
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// objectStore is a Store backed by an object storage bucket (Amazon S3 or
+// Google Cloud Storage). Per-issue objects use the same file names as
+// fsStore but under an "issues/" key prefix ("prefix/issues/0001.json",
+// "prefix/issues/0001.comments.json"), and a "prefix/meta.json" object is
+// kept alongside them.
+type objectStore struct {
+	// backend is either "s3" or "gs".
+	backend string
+	bucket  string
+	prefix  string
+
+	ctx context.Context
+	s3  *s3.S3
+	gcs *storage.Client
+
+	meta objectMeta
+}
+
+// newObjectStore returns a Store backed by the given bucket/prefix of the
+// named backend ("s3" or "gs"). Credentials are resolved from the
+// backend's standard environment, similar to how newClient wires the
+// GitHub OAuth token.
+func newObjectStore(backend, bucketAndPrefix string) (*objectStore, error) {
+	bucket, prefix := bucketAndPrefix, ""
+	if i := strings.Index(bucketAndPrefix, "/"); i >= 0 {
+		bucket, prefix = bucketAndPrefix[:i], bucketAndPrefix[i+1:]
+	}
+	ctx := context.Background()
+	s := &objectStore{
+		backend: backend,
+		bucket:  bucket,
+		prefix:  prefix,
+		ctx:     ctx,
+	}
+	switch backend {
+	case "s3":
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		s.s3 = s3.New(sess)
+	case "gs":
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		s.gcs = client
+	default:
+		return nil, errors.Errorf(`unsupported object store backend %q (want "s3" or "gs")`, backend)
+	}
+	buf, err := s.get(s.metaKey())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if buf != nil {
+		if err := json.Unmarshal(buf, &s.meta); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return s, nil
+}
+
+// key joins the store's prefix with the given path.
+func (s *objectStore) key(path string) string {
+	if len(s.prefix) == 0 {
+		return path
+	}
+	return s.prefix + "/" + path
+}
+
+func (s *objectStore) issueKey(issueNumber int) string {
+	return s.key(fmt.Sprintf("issues/%04d.json", issueNumber))
+}
+
+func (s *objectStore) commentsKey(issueNumber int) string {
+	return s.key(fmt.Sprintf("issues/%04d.comments.json", issueNumber))
+}
+
+func (s *objectStore) metaKey() string {
+	return s.key("meta.json")
+}
+
+func (s *objectStore) reactionsKey(issueNumber int) string {
+	return s.key(fmt.Sprintf("issues/%04d.reactions.json", issueNumber))
+}
+
+func (s *objectStore) timelineKey(issueNumber int) string {
+	return s.key(fmt.Sprintf("issues/%04d.timeline.json", issueNumber))
+}
+
+func (s *objectStore) pullRequestKey(issueNumber int) string {
+	return s.key(fmt.Sprintf("issues/%04d.pr.json", issueNumber))
+}
+
+func (s *objectStore) labelsKey() string {
+	return s.key("labels.json")
+}
+
+func (s *objectStore) milestonesKey() string {
+	return s.key("milestones.json")
+}
+
+// get downloads the object at key, returning a nil slice (and nil error) if
+// it does not exist.
+func (s *objectStore) get(key string) ([]byte, error) {
+	switch s.backend {
+	case "s3":
+		out, err := s.s3.GetObjectWithContext(s.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			if isNotFound(err) {
+				return nil, nil
+			}
+			return nil, errors.WithStack(err)
+		}
+		defer out.Body.Close()
+		return ioutil.ReadAll(out.Body)
+	default: // "gs"
+		r, err := s.gcs.Bucket(s.bucket).Object(key).NewReader(s.ctx)
+		if err != nil {
+			if err == storage.ErrObjectNotExist {
+				return nil, nil
+			}
+			return nil, errors.WithStack(err)
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+}
+
+// put uploads data to the object at key, overwriting any existing object.
+func (s *objectStore) put(key string, data []byte) error {
+	switch s.backend {
+	case "s3":
+		_, err := s.s3.PutObjectWithContext(s.ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		})
+		return errors.WithStack(err)
+	default: // "gs"
+		w := s.gcs.Bucket(s.bucket).Object(key).NewWriter(s.ctx)
+		if _, err := w.Write(data); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(w.Close())
+	}
+}
+
+// PutIssue persists the given issue, overwriting any previously stored
+// version of the same issue.
+func (s *objectStore) PutIssue(issue *github.Issue) error {
+	data, err := json.Marshal(issue)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.put(s.issueKey(issue.GetNumber()), data); err != nil {
+		return errors.WithStack(err)
+	}
+	if updated := issue.GetUpdatedAt(); updated.After(s.meta.LastUpdated) {
+		s.meta.LastUpdated = updated
+		meta, err := json.Marshal(s.meta)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := s.put(s.metaKey(), meta); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// PutComments persists the comments of the given issue number, merging into
+// any comments already stored for the issue (see mergeComments).
+func (s *objectStore) PutComments(issueNumber int, comments []*github.IssueComment) error {
+	key := s.commentsKey(issueNumber)
+	buf, err := s.get(key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var existing []*github.IssueComment
+	if buf != nil {
+		if err := json.Unmarshal(buf, &existing); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	data, err := json.Marshal(mergeComments(existing, comments))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(s.put(key, data))
+}
+
+// PutReactions persists the reactions of the given issue number.
+func (s *objectStore) PutReactions(issueNumber int, reactions []*github.Reaction) error {
+	return s.putJSON(s.reactionsKey(issueNumber), reactions)
+}
+
+// PutTimeline persists the timeline events of the given issue number.
+func (s *objectStore) PutTimeline(issueNumber int, events []*github.Timeline) error {
+	return s.putJSON(s.timelineKey(issueNumber), events)
+}
+
+// PutLabels persists the repository's labels.
+func (s *objectStore) PutLabels(labels []*github.Label) error {
+	return s.putJSON(s.labelsKey(), labels)
+}
+
+// PutMilestones persists the repository's milestones.
+func (s *objectStore) PutMilestones(milestones []*github.Milestone) error {
+	return s.putJSON(s.milestonesKey(), milestones)
+}
+
+// PutPullRequest persists the pull request data of the given issue number.
+func (s *objectStore) PutPullRequest(issueNumber int, pr *PullRequestData) error {
+	return s.putJSON(s.pullRequestKey(issueNumber), pr)
+}
+
+// putJSON marshals v to JSON and uploads it to the object at key.
+func (s *objectStore) putJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(s.put(key, data))
+}
+
+// LastUpdated returns the `updated_at` timestamp of the most recently
+// stored issue, as tracked in the store's meta object.
+func (s *objectStore) LastUpdated() (time.Time, error) {
+	return s.meta.LastUpdated, nil
+}
+
+// Close releases the underlying cloud storage client, if any.
+func (s *objectStore) Close() error {
+	if s.gcs != nil {
+		return errors.WithStack(s.gcs.Close())
+	}
+	return nil
+}
+
+// isNotFound reports whether err is an S3 "not found" error.
+func isNotFound(err error) bool {
+	type requestFailure interface {
+		StatusCode() int
+	}
+	if rf, ok := err.(requestFailure); ok {
+		return rf.StatusCode() == 404
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// defaultSQLiteFile is the default database file of the sqlite store.
+const defaultSQLiteFile = "issues.db"
+
+// sqliteStore is a Store backed by a SQLite database, so that backups can
+// be queried with plain SQL (e.g. "which issues mention X", "how many
+// comments per label").
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// sqliteSchema creates the tables used by the sqlite store, if they do not
+// already exist.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS issues (
+	number     INTEGER PRIMARY KEY,
+	title      TEXT NOT NULL,
+	body       TEXT NOT NULL,
+	state      TEXT NOT NULL,
+	author     TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS comments (
+	id          INTEGER PRIMARY KEY,
+	issue_number INTEGER NOT NULL REFERENCES issues(number),
+	author      TEXT NOT NULL,
+	created_at  DATETIME NOT NULL,
+	data        TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS labels (
+	issue_number INTEGER NOT NULL REFERENCES issues(number),
+	name         TEXT NOT NULL,
+	PRIMARY KEY (issue_number, name)
+);
+CREATE TABLE IF NOT EXISTS repo_labels (
+	name TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS milestones (
+	number INTEGER PRIMARY KEY,
+	data   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS reactions (
+	issue_number INTEGER PRIMARY KEY REFERENCES issues(number),
+	data         TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS timeline_events (
+	issue_number INTEGER PRIMARY KEY REFERENCES issues(number),
+	data         TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS pull_requests (
+	issue_number INTEGER PRIMARY KEY REFERENCES issues(number),
+	data         TEXT NOT NULL
+);
+`
+
+// newSQLiteStore opens (and initializes, if necessary) a sqlite store
+// backed by the database file at path.
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// PutIssue persists the given issue, overwriting any previously stored
+// version of the same issue.
+func (s *sqliteStore) PutIssue(issue *github.Issue) error {
+	data, err := json.Marshal(issue)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO issues (number, title, body, state, author, created_at, updated_at, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (number) DO UPDATE SET
+			title = excluded.title,
+			body = excluded.body,
+			state = excluded.state,
+			author = excluded.author,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			data = excluded.data
+	`, issue.GetNumber(), issue.GetTitle(), issue.GetBody(), issue.GetState(), issue.GetUser().GetLogin(), issue.GetCreatedAt(), issue.GetUpdatedAt(), string(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM labels WHERE issue_number = ?`, issue.GetNumber()); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, label := range issue.Labels {
+		if _, err := s.db.Exec(`INSERT OR IGNORE INTO labels (issue_number, name) VALUES (?, ?)`, issue.GetNumber(), label.GetName()); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// PutComments persists the comments of the given issue number.
+func (s *sqliteStore) PutComments(issueNumber int, comments []*github.IssueComment) error {
+	for _, comment := range comments {
+		data, err := json.Marshal(comment)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = s.db.Exec(`
+			INSERT INTO comments (id, issue_number, author, created_at, data)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET
+				author = excluded.author,
+				created_at = excluded.created_at,
+				data = excluded.data
+		`, comment.GetID(), issueNumber, comment.GetUser().GetLogin(), comment.GetCreatedAt(), string(data))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// PutReactions persists the reactions of the given issue number.
+func (s *sqliteStore) PutReactions(issueNumber int, reactions []*github.Reaction) error {
+	return s.putJSON(`
+		INSERT INTO reactions (issue_number, data) VALUES (?, ?)
+		ON CONFLICT (issue_number) DO UPDATE SET data = excluded.data
+	`, issueNumber, reactions)
+}
+
+// PutTimeline persists the timeline events of the given issue number.
+func (s *sqliteStore) PutTimeline(issueNumber int, events []*github.Timeline) error {
+	return s.putJSON(`
+		INSERT INTO timeline_events (issue_number, data) VALUES (?, ?)
+		ON CONFLICT (issue_number) DO UPDATE SET data = excluded.data
+	`, issueNumber, events)
+}
+
+// PutLabels persists the repository's labels.
+func (s *sqliteStore) PutLabels(labels []*github.Label) error {
+	if _, err := s.db.Exec(`DELETE FROM repo_labels`); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, label := range labels {
+		if err := s.putJSON(`
+			INSERT INTO repo_labels (name, data) VALUES (?, ?)
+			ON CONFLICT (name) DO UPDATE SET data = excluded.data
+		`, label.GetName(), label); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// PutMilestones persists the repository's milestones.
+func (s *sqliteStore) PutMilestones(milestones []*github.Milestone) error {
+	if _, err := s.db.Exec(`DELETE FROM milestones`); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, milestone := range milestones {
+		if err := s.putJSON(`
+			INSERT INTO milestones (number, data) VALUES (?, ?)
+			ON CONFLICT (number) DO UPDATE SET data = excluded.data
+		`, milestone.GetNumber(), milestone); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// PutPullRequest persists the pull request data of the given issue number.
+func (s *sqliteStore) PutPullRequest(issueNumber int, pr *PullRequestData) error {
+	return s.putJSON(`
+		INSERT INTO pull_requests (issue_number, data) VALUES (?, ?)
+		ON CONFLICT (issue_number) DO UPDATE SET data = excluded.data
+	`, issueNumber, pr)
+}
+
+// putJSON marshals v to JSON and executes query with the given key followed
+// by the marshaled data as arguments.
+func (s *sqliteStore) putJSON(query string, key interface{}, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = s.db.Exec(query, key, string(data))
+	return errors.WithStack(err)
+}
+
+// LastUpdated returns the `updated_at` timestamp of the most recently
+// stored issue.
+func (s *sqliteStore) LastUpdated() (time.Time, error) {
+	// Select the column itself rather than MAX(updated_at): only a column
+	// declared DATETIME is decoded by the driver into a time.Time.
+	var lastUpdated time.Time
+	row := s.db.QueryRow(`SELECT updated_at FROM issues ORDER BY updated_at DESC LIMIT 1`)
+	if err := row.Scan(&lastUpdated); err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.WithStack(err)
+	}
+	return lastUpdated, nil
+}
+
+// Close closes the underlying database connection.
+func (s *sqliteStore) Close() error {
+	return errors.WithStack(s.db.Close())
+}
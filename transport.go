@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/gregjones/httpcache/diskcache"
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+)
+
+// defaultRateFraction is the default -rate-fraction flag value: the
+// fraction of the GitHub rate limit this tool targets, leaving the
+// remainder for other tools sharing the same token.
+const defaultRateFraction = 0.5
+
+// maxSecondaryRetries bounds how many times rateLimitTransport backs off
+// and retries a request that hit GitHub's secondary/abuse rate limit,
+// before giving up and returning the response to the caller.
+const maxSecondaryRetries = 5
+
+// rateLimitTransport is an http.RoundTripper that proactively paces
+// requests with a token bucket sized from the `X-RateLimit-Limit` response
+// header, and backs off with jitter when GitHub returns a secondary/abuse
+// rate-limit response (HTTP 403 with `Retry-After` set).
+type rateLimitTransport struct {
+	next     http.RoundTripper
+	fraction float64
+
+	mu      sync.Mutex
+	limiter *rate.Limiter
+}
+
+// newRateLimitTransport wraps next with proactive rate pacing, targeting
+// the given fraction of the GitHub rate limit. The limiter starts
+// conservative (the default primary limit of 5000 requests/hour) and is
+// tightened or loosened as soon as a response reveals the real limit.
+func newRateLimitTransport(next http.RoundTripper, fraction float64) *rateLimitTransport {
+	return &rateLimitTransport{
+		next:     next,
+		fraction: fraction,
+		limiter:  rate.NewLimiter(rate.Limit(5000*fraction/3600), 1),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		t.adjustLimit(resp)
+		if attempt >= maxSecondaryRetries || !isSecondaryRateLimit(resp) {
+			return resp, nil
+		}
+		delay := secondaryRetryDelay(resp, attempt)
+		dbg.Printf("secondary rate limit hit; backing off for %v before retrying", delay)
+		time.Sleep(delay)
+	}
+}
+
+// adjustLimit retunes the token bucket from the `X-RateLimit-Limit` header
+// of resp, if present, targeting t.fraction of the advertised limit.
+func (t *rateLimitTransport) adjustLimit(resp *http.Response) {
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil || limit <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limiter.SetLimit(rate.Limit(float64(limit) * t.fraction / 3600))
+}
+
+// isSecondaryRateLimit reports whether resp is a GitHub secondary/abuse
+// rate-limit response, as opposed to the primary rate limit (which
+// *github.RateLimitError already models and waitForRateLimitReset handles).
+// A 403 with `X-RateLimit-Remaining: 0` is not sufficient on its own to
+// tell the two apart, since the primary limit reports the same pair; only
+// the secondary/abuse limit sets `Retry-After`.
+func isSecondaryRateLimit(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusForbidden && len(resp.Header.Get("Retry-After")) > 0
+}
+
+// secondaryRetryDelay computes how long to wait before retrying a request
+// that hit the secondary rate limit, honoring `Retry-After` when present
+// and otherwise falling back to exponential backoff. Up to a second of
+// jitter is added in either case.
+func secondaryRetryDelay(resp *http.Response, attempt int) time.Duration {
+	jitter := time.Duration(rand.Int63n(int64(time.Second)))
+	if s := resp.Header.Get("Retry-After"); len(s) > 0 {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return time.Duration(secs)*time.Second + jitter
+		}
+	}
+	backoff := time.Duration(1<<uint(attempt)) * time.Second
+	return backoff + jitter
+}
+
+// newCachingTransport wraps next with an ETag-aware conditional GET cache,
+// so that re-running a backup against unchanged issues costs 0 rate-limit
+// units. Responses are cached on disk under cacheDir, or in memory if
+// cacheDir is empty.
+func newCachingTransport(next http.RoundTripper, cacheDir string) http.RoundTripper {
+	var cache httpcache.Cache
+	if len(cacheDir) > 0 {
+		cache = diskcache.New(cacheDir)
+	} else {
+		cache = httpcache.NewMemoryCache()
+	}
+	t := httpcache.NewTransport(cache)
+	t.Transport = next
+	return t
+}
+
+// newHTTPClient returns an http.Client authenticated with the given OAuth
+// token (if any), wrapped with rate-limit pacing/backoff and, if cacheDir is
+// set or left empty for an in-memory cache, conditional-GET caching. It
+// backs both the REST client (newClient) and the GraphQL client
+// (newGraphQLClient).
+func newHTTPClient(ctx context.Context, token string, rateFraction float64, cacheDir string) *http.Client {
+	var rt http.RoundTripper = http.DefaultTransport
+	if len(token) > 0 {
+		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+		rt = &oauth2.Transport{Source: ts, Base: rt}
+	}
+	rt = newRateLimitTransport(rt, rateFraction)
+	rt = newCachingTransport(rt, cacheDir)
+	return &http.Client{Transport: rt}
+}